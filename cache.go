@@ -0,0 +1,251 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// icsCacheTTL is how long a cached parse is trusted without revalidating
+// against the origin server.
+const icsCacheTTL = 5 * time.Minute
+
+// icsCacheMaxEntries bounds memory use: once exceeded, the least recently
+// used calendar is evicted.
+const icsCacheMaxEntries = 256
+
+// expansionCacheTTL bounds how long a memoized recurrence-expansion result
+// is reused, independent of the backing calendar's own TTL above.
+const expansionCacheTTL = 10 * time.Minute
+
+// expansionCacheMaxEntries bounds memory use for the expansion cache.
+const expansionCacheMaxEntries = 512
+
+// icsCacheEntry is one cached, parsed calendar plus the validators needed to
+// conditionally revalidate it with the origin server.
+type icsCacheEntry struct {
+	url          string
+	calendar     *ics.Calendar
+	etag         string
+	lastModified string
+	fetchedAt    time.Time
+}
+
+// revision is a short, stable tag identifying this entry's content, used as
+// part of the expansion cache key. It prefers the server's ETag, falls back
+// to Last-Modified, and finally to the fetch time, so a server offering
+// neither validator still gets expansion memoization for the entry's TTL.
+func (e *icsCacheEntry) revision() string {
+	if e.etag != "" {
+		return e.etag
+	}
+	if e.lastModified != "" {
+		return e.lastModified
+	}
+	return e.fetchedAt.Format(time.RFC3339Nano)
+}
+
+// icsCache is a small in-process LRU cache of parsed ICS calendars, keyed by
+// decoded source URL.
+type icsCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+func newICSCache() *icsCache {
+	return &icsCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+var globalICSCache = newICSCache()
+
+func (c *icsCache) get(url string) (*icsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*icsCacheEntry), true
+}
+
+func (c *icsCache) put(entry *icsCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.url]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	el := c.order.PushFront(entry)
+	c.entries[entry.url] = el
+	for len(c.entries) > icsCacheMaxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*icsCacheEntry).url)
+	}
+}
+
+// fetchICSCalendar fetches and parses a static ICS document, reusing a
+// cached parse when its TTL hasn't elapsed, and otherwise issuing a
+// conditional GET (If-None-Match/If-Modified-Since) so a 304 can reuse the
+// cached parse without re-downloading or re-parsing anything. Passing
+// noCache forces a full, unconditional refetch. It returns the calendar
+// along with a revision tag identifying its content, for use as an
+// expansion cache key.
+func fetchICSCalendar(icsURL string, noCache bool) (*ics.Calendar, string, error) {
+	var cached *icsCacheEntry
+	if !noCache {
+		if entry, ok := globalICSCache.get(icsURL); ok {
+			if time.Since(entry.fetchedAt) < icsCacheTTL {
+				return entry.calendar, entry.revision(), nil
+			}
+			cached = entry
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, icsURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build ICS request: %w", err)
+	}
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch ICS URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		// Build a fresh entry rather than mutating cached's fetchedAt in
+		// place: cached is the shared *icsCacheEntry returned by get(), and
+		// writing to it outside of put()'s lock would race with another
+		// concurrent revalidation of the same popular, stale URL.
+		revalidated := &icsCacheEntry{
+			url:          cached.url,
+			calendar:     cached.calendar,
+			etag:         cached.etag,
+			lastModified: cached.lastModified,
+			fetchedAt:    time.Now(),
+		}
+		globalICSCache.put(revalidated)
+		return revalidated.calendar, revalidated.revision(), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to retrieve ICS file: status %d", resp.StatusCode)
+	}
+
+	icsData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read ICS data: %w", err)
+	}
+
+	calendar, err := ics.ParseCalendar(strings.NewReader(string(icsData)))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse ICS data: %w", err)
+	}
+
+	entry := &icsCacheEntry{
+		url:          icsURL,
+		calendar:     calendar,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		fetchedAt:    time.Now(),
+	}
+	globalICSCache.put(entry)
+
+	return calendar, entry.revision(), nil
+}
+
+// expansionCacheKey identifies one recurrence-expansion result: the
+// calendar source plus its revision tag, the requested window, and zone.
+// source must be included alongside revision, not just revision alone:
+// revision falls back to a bare Last-Modified header when the origin sends
+// no ETag (see icsCacheEntry.revision), and two different sources hosted on
+// the same origin (e.g. a shared Nextcloud instance serving many users'
+// calendars) can easily share that value, which would otherwise let one
+// caller's request be served out of another calendar's cached entry.
+type expansionCacheKey struct {
+	source   string
+	revision string
+	start    int64
+	end      int64
+	tz       string
+}
+
+type expansionCacheEntry struct {
+	key      expansionCacheKey
+	events   []Event
+	cachedAt time.Time
+}
+
+// expansionCache memoizes collectEvents' output, since recurrence expansion
+// is the expensive step and popular windows (e.g. "today") are requested
+// repeatedly for a calendar that hasn't changed.
+type expansionCache struct {
+	mu      sync.Mutex
+	entries map[expansionCacheKey]*list.Element
+	order   *list.List
+}
+
+func newExpansionCache() *expansionCache {
+	return &expansionCache{entries: make(map[expansionCacheKey]*list.Element), order: list.New()}
+}
+
+var globalExpansionCache = newExpansionCache()
+
+func (c *expansionCache) get(key expansionCacheKey) ([]Event, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*expansionCacheEntry)
+	if time.Since(entry.cachedAt) > expansionCacheTTL {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.events, true
+}
+
+func (c *expansionCache) put(key expansionCacheKey, events []Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &expansionCacheEntry{key: key, events: events, cachedAt: time.Now()}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	el := c.order.PushFront(entry)
+	c.entries[key] = el
+	for len(c.entries) > expansionCacheMaxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*expansionCacheEntry).key)
+	}
+}
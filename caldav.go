@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+)
+
+// calendarQueryReportTemplate is a CalDAV REPORT body (RFC 4791 §7.8) that
+// narrows a collection to VEVENTs overlapping a time-range, so the server
+// doesn't have to transfer and parse the whole calendar.
+const calendarQueryReportTemplate = `<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+
+// caldavMultistatus is the subset of a CalDAV REPORT multistatus response we
+// care about: one response per matched resource, each carrying its raw
+// calendar-data.
+type caldavMultistatus struct {
+	XMLName   xml.Name         `xml:"multistatus"`
+	Responses []caldavResponse `xml:"response"`
+}
+
+type caldavResponse struct {
+	Href string `xml:"href"`
+	Prop struct {
+		GetETag      string `xml:"getetag"`
+		CalendarData string `xml:"calendar-data"`
+	} `xml:"propstat>prop"`
+}
+
+// fetchCalendarSource resolves a request's calendar source -- either a
+// plain ICS URL (the `ics` parameter) or a CalDAV collection (the `caldav`
+// parameter) -- and returns the parsed calendar along with a revision tag
+// identifying its content (used as part of the expansion cache key; see
+// cache.go). The caller has already confirmed at least one of the two
+// parameters is set.
+func fetchCalendarSource(c *gin.Context, windowStart, windowEnd time.Time) (*ics.Calendar, string, error) {
+	noCache := c.Query("nocache") == "1"
+
+	if caldavURL := c.Query("caldav"); caldavURL != "" {
+		return fetchCalDAVCalendar(c, caldavURL, windowStart, windowEnd)
+	}
+
+	decodedURL, err := url.QueryUnescape(c.Query("ics"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid 'ics' URL: %w", err)
+	}
+	return fetchICSCalendar(decodedURL, noCache)
+}
+
+// fetchCalDAVCalendar issues a calendar-query REPORT against a CalDAV
+// collection, narrowed to [windowStart, windowEnd), and merges every
+// returned calendar-data blob into a single calendar. The revision tag is
+// derived from the resources' ETags, since CalDAV REPORTs aren't themselves
+// conditionally revalidatable the way a plain ICS GET is.
+func fetchCalDAVCalendar(c *gin.Context, rawURL string, windowStart, windowEnd time.Time) (*ics.Calendar, string, error) {
+	decodedURL, err := url.QueryUnescape(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid 'caldav' URL: %w", err)
+	}
+
+	username, password, err := caldavCredentials(c, decodedURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body := fmt.Sprintf(calendarQueryReportTemplate,
+		windowStart.UTC().Format("20060102T150405Z"),
+		windowEnd.UTC().Format("20060102T150405Z"))
+
+	req, err := http.NewRequest("REPORT", decodedURL, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build CalDAV REPORT request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch CalDAV collection: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("CalDAV REPORT failed: status %d", resp.StatusCode)
+	}
+
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read CalDAV response: %w", err)
+	}
+
+	var ms caldavMultistatus
+	if err := xml.Unmarshal(respData, &ms); err != nil {
+		return nil, "", fmt.Errorf("failed to parse CalDAV multistatus: %w", err)
+	}
+
+	merged := ics.NewCalendar()
+	var etags []string
+	for _, r := range ms.Responses {
+		if r.Prop.GetETag != "" {
+			etags = append(etags, r.Href+":"+r.Prop.GetETag)
+		}
+		if r.Prop.CalendarData == "" {
+			continue
+		}
+		cal, err := ics.ParseCalendar(strings.NewReader(r.Prop.CalendarData))
+		if err != nil {
+			continue
+		}
+		merged.Components = append(merged.Components, cal.Components...)
+	}
+
+	return merged, strings.Join(etags, "|"), nil
+}
+
+// caldavCredentials resolves HTTP Basic credentials for a CalDAV request,
+// preferring (in order) an Authorization header on the inbound request and
+// the collection URL's own userinfo. The CALDAV_USERNAME/CALDAV_PASSWORD
+// environment variables are only used as a last resort, and only when
+// rawURL's host matches CALDAV_ALLOWED_HOST: rawURL is a caller-supplied
+// `caldav` parameter, and handing a fixed operator credential to whatever
+// host a caller names would let any caller exfiltrate it by pointing
+// caldav at a server they control.
+func caldavCredentials(c *gin.Context, rawURL string) (username, password string, err error) {
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		const prefix = "Basic "
+		if !strings.HasPrefix(authHeader, prefix) {
+			return "", "", fmt.Errorf("unsupported Authorization scheme")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, prefix))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid Authorization header: %w", err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid Authorization header")
+		}
+		return parts[0], parts[1], nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err == nil && parsed.User != nil {
+		pass, _ := parsed.User.Password()
+		return parsed.User.Username(), pass, nil
+	}
+
+	allowedHost := os.Getenv("CALDAV_ALLOWED_HOST")
+	if err != nil || allowedHost == "" || parsed.Host != allowedHost {
+		return "", "", nil
+	}
+	return os.Getenv("CALDAV_USERNAME"), os.Getenv("CALDAV_PASSWORD"), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+)
+
+// BusyInterval is one merged, opaque busy period returned by /freebusy.
+// Unlike Event it deliberately carries no summary/location/description, so
+// callers can check availability without leaking calendar details.
+type BusyInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// freebusyHandler handles the /freebusy endpoint: it unions the busy
+// intervals of one or more calendar sources over a window, coalescing
+// overlapping events into a single timeline and skipping anything marked
+// TRANSP:TRANSPARENT.
+func freebusyHandler(c *gin.Context) {
+	icsURLs := c.QueryArray("ics")
+	caldavURL := c.Query("caldav")
+	if len(icsURLs) == 0 && caldavURL == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Missing 'ics' or 'caldav' parameter"})
+		return
+	}
+
+	windowStart, windowEnd, err := resolveWindow(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	noCache := c.Query("nocache") == "1"
+
+	var calendars []*ics.Calendar
+	for _, rawURL := range icsURLs {
+		decodedURL, err := url.QueryUnescape(rawURL)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid 'ics' URL"})
+			return
+		}
+		calendar, _, err := fetchICSCalendar(decodedURL, noCache)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		calendars = append(calendars, calendar)
+	}
+
+	if caldavURL != "" {
+		calendar, _, err := fetchCalDAVCalendar(c, caldavURL, windowStart, windowEnd)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		calendars = append(calendars, calendar)
+	}
+
+	var intervals []BusyInterval
+	for _, calendar := range calendars {
+		events, err := collectEvents(calendar, windowStart, windowEnd)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process calendar events"})
+			return
+		}
+		for _, event := range events {
+			if event.Transparent {
+				continue
+			}
+			intervals = append(intervals, BusyInterval{Start: event.Start, End: event.End})
+		}
+	}
+
+	c.JSON(http.StatusOK, mergeBusyIntervals(intervals))
+}
+
+// mergeBusyIntervals sorts busy intervals by start time and coalesces any
+// that overlap or abut into a single interval.
+func mergeBusyIntervals(intervals []BusyInterval) []BusyInterval {
+	if len(intervals) == 0 {
+		return []BusyInterval{}
+	}
+
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Start.Before(intervals[j].Start)
+	})
+
+	merged := []BusyInterval{intervals[0]}
+	for _, interval := range intervals[1:] {
+		last := &merged[len(merged)-1]
+		if interval.Start.After(last.End) {
+			merged = append(merged, interval)
+			continue
+		}
+		if interval.End.After(last.End) {
+			last.End = interval.End
+		}
+	}
+
+	return merged
+}
@@ -1,14 +1,10 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -25,93 +21,101 @@ type Event struct {
 	Start       time.Time `json:"start"`
 	End         time.Time `json:"end"`
 	AllDay      bool      `json:"all_day"`
+	// RecurrenceID is set for instances produced by recurrence expansion; it
+	// holds the instance's original (un-overridden) start time. Nil for
+	// non-recurring events.
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
+	// Transparent reflects TRANSP:TRANSPARENT -- the event doesn't consume
+	// time on the calendar's busy timeline. Not part of the public API.
+	Transparent bool `json:"-"`
 }
 
 // caljsonHandler handles the /caljson endpoint.
 func caljsonHandler(c *gin.Context) {
-	// Parse query parameters
-	icsURL := c.Query("ics")
-	dayStr := c.DefaultQuery("day", "0")
-
 	// Validate query parameters
-	if icsURL == "" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Missing 'ics' parameter"})
-		return
-	}
-
-	dayOffset, err := strconv.Atoi(dayStr)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid 'day' parameter"})
+	if c.Query("ics") == "" && c.Query("caldav") == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Missing 'ics' or 'caldav' parameter"})
 		return
 	}
 
-	// Decode the ICS URL
-	decodedIcsURL, err := url.QueryUnescape(icsURL)
+	targetStart, targetEnd, err := resolveWindow(c)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid 'ics' URL"})
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Log the request details
-	fmt.Println("Request from:", c.ClientIP(), "for", decodedIcsURL)
+	fmt.Println("Request from:", c.ClientIP(), "for", c.Query("ics")+c.Query("caldav"))
 
-	// Fetch the ICS data
-	resp, err := http.Get(decodedIcsURL)
+	// Fetch and parse the calendar, whichever source it comes from
+	calendar, revision, err := fetchCalendarSource(c, targetStart, targetEnd)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ICS URL"})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ICS file"})
-		return
+	// Recurrence expansion is the expensive step, so memoize its result per
+	// (source, calendar revision, window, zone) and skip straight to it on a
+	// hit. source is keyed separately from revision since revision alone
+	// isn't guaranteed unique across different sources (see
+	// expansionCacheKey's doc comment).
+	source := "ics:" + c.Query("ics")
+	if c.Query("ics") == "" {
+		source = "caldav:" + c.Query("caldav")
 	}
-
-	icsData, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to read ICS data"})
-		return
+	cacheKey := expansionCacheKey{
+		source:   source,
+		revision: revision,
+		start:    targetStart.UTC().Unix(),
+		end:      targetEnd.UTC().Unix(),
+		tz:       targetStart.Location().String(),
 	}
 
-	// Parse the ICS data
-	calendar, err := ics.ParseCalendar(strings.NewReader(string(icsData)))
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse ICS data"})
-		return
-	}
+	events, ok := globalExpansionCache.get(cacheKey)
+	if !ok {
+		// Collect events in the target window, expanding RRULE/RDATE
+		// recurrences and applying RECURRENCE-ID overrides.
+		events, err = collectEvents(calendar, targetStart, targetEnd)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process calendar events"})
+			return
+		}
 
-	// Determine the target date (start and end of the day)
-	now := time.Now()
-	targetDate := now.AddDate(0, 0, dayOffset)
-	targetStart := time.Date(targetDate.Year(), targetDate.Month(), targetDate.Day(), 0, 0, 0, 0, time.Local)
-	targetEnd := targetStart.Add(24 * time.Hour)
+		// Sort events by start time
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].Start.Before(events[j].Start)
+		})
 
-	// Collect events on the target date
-	var events []Event
-	for _, component := range calendar.Components {
-		if vevent, ok := component.(*ics.VEvent); ok {
-			event, err := parseEvent(vevent, targetStart, targetEnd)
-			if err != nil {
-				continue
-			}
-			if event != nil {
-				events = append(events, *event)
-			}
-		}
+		globalExpansionCache.put(cacheKey, events)
 	}
 
-	// Sort events by start time
-	sort.Slice(events, func(i, j int) bool {
-		return events[i].Start.Before(events[j].Start)
-	})
-
 	// Return events as JSON
 	c.JSON(http.StatusOK, events)
 }
 
-// parseEvent extracts event details and checks if it occurs on the target date.
-func parseEvent(vevent *ics.VEvent, targetStart, targetEnd time.Time) (*Event, error) {
+// parseEvent extracts event details and checks if it occurs within the
+// target window. It does not expand recurrences; see expandOccurrences for
+// RRULE/RDATE-bearing events.
+func parseEvent(vevent *ics.VEvent, targetStart, targetEnd time.Time, resolver *tzResolver) (*Event, error) {
+	event, err := parseEventCore(vevent, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the event occurs within the target window
+	if event.End.After(targetStart) && event.Start.Before(targetEnd) {
+		return &event, nil
+	}
+
+	return nil, nil // Event not in target window
+}
+
+// parseEventCore extracts an Event's static fields and its DTSTART/DTEND,
+// without checking whether it falls inside any particular window. This is
+// the shared core used by both single-occurrence events (parseEvent) and
+// recurrence expansion (expandOccurrences). resolver is used to turn a
+// TZID into a *time.Location; pass nil to fall back to plain IANA lookups.
+func parseEventCore(vevent *ics.VEvent, resolver *tzResolver) (Event, error) {
 	// Get event properties
 	startProp := vevent.GetProperty(ics.ComponentPropertyDtStart)
 	endProp := vevent.GetProperty(ics.ComponentPropertyDtEnd)
@@ -128,7 +132,7 @@ func parseEvent(vevent *ics.VEvent, targetStart, targetEnd time.Time) (*Event, e
 	)
 
 	if startProp == nil {
-		return nil, fmt.Errorf("event missing DTSTART")
+		return Event{}, fmt.Errorf("event missing DTSTART")
 	}
 
 	// Check if the event is all-day
@@ -138,13 +142,13 @@ func parseEvent(vevent *ics.VEvent, targetStart, targetEnd time.Time) (*Event, e
 		allDay = true
 		start, err = time.Parse("20060102", startProp.Value)
 		if err != nil {
-			return nil, err
+			return Event{}, err
 		}
 		// DTEND is exclusive; adjust end date
 		if endProp != nil {
 			end, err = time.Parse("20060102", endProp.Value)
 			if err != nil {
-				return nil, err
+				return Event{}, err
 			}
 		} else {
 			// If DTEND is missing, assume one-day event
@@ -152,14 +156,14 @@ func parseEvent(vevent *ics.VEvent, targetStart, targetEnd time.Time) (*Event, e
 		}
 	} else {
 		// Timed event
-		start, err = parseICalTime(startProp.Value, startProp)
+		start, err = parseICalTime(startProp.Value, startProp, resolver)
 		if err != nil {
-			return nil, err
+			return Event{}, err
 		}
 		if endProp != nil {
-			end, err = parseICalTime(endProp.Value, endProp)
+			end, err = parseICalTime(endProp.Value, endProp, resolver)
 			if err != nil {
-				return nil, err
+				return Event{}, err
 			}
 		} else {
 			// If DTEND is missing, assume zero-duration event
@@ -167,45 +171,66 @@ func parseEvent(vevent *ics.VEvent, targetStart, targetEnd time.Time) (*Event, e
 		}
 	}
 
-	// Adjust for time zones if TZID is present
+	// Adjust for time zones if TZID is present. start/end are already
+	// correctly zoned by parseICalTime; resolving again against their own
+	// wall-clock reading (as parseICalTime does) is a no-op, kept here so an all-day-shaped but TZID-tagged
+	// DTSTART (VALUE=DATE with an explicit TZID) still gets zoned.
 	if tzidVals, ok := startProp.ICalParameters["TZID"]; ok {
 		if len(tzidVals) > 0 {
-			loc, err := time.LoadLocation(tzidVals[0])
-			if err == nil {
-				start = start.In(loc)
-			}
+			wall := time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), time.UTC)
+			start = start.In(resolver.resolve(tzidVals[0], wall))
 		}
 	}
 
 	if endProp != nil {
 		if tzidVals, ok := endProp.ICalParameters["TZID"]; ok {
 			if len(tzidVals) > 0 {
-				loc, err := time.LoadLocation(tzidVals[0])
-				if err == nil {
-					end = end.In(loc)
-				}
+				wall := time.Date(end.Year(), end.Month(), end.Day(), end.Hour(), end.Minute(), end.Second(), end.Nanosecond(), time.UTC)
+				end = end.In(resolver.resolve(tzidVals[0], wall))
 			}
 		}
 	}
 
-	// Check if the event occurs on the target date
-	if end.After(targetStart) && start.Before(targetEnd) {
-		return &Event{
-			UID:         uidProp.Value,
-			Summary:     summaryProp.Value,
-			Description: descriptionProp.Value,
-			Location:    locationProp.Value,
-			Start:       start,
-			End:         end,
-			AllDay:      allDay,
-		}, nil
+	transparent := false
+	if transpProp := vevent.GetProperty(ics.ComponentPropertyTransp); transpProp != nil {
+		transparent = strings.EqualFold(transpProp.Value, "TRANSPARENT")
+	}
+
+	// DESCRIPTION, LOCATION, SUMMARY, and even UID are all optional
+	// properties -- GetProperty returns nil when a VEVENT omits them, so
+	// each needs its own nil check rather than a bare .Value dereference.
+	var uid, summary, description, location string
+	if uidProp != nil {
+		uid = uidProp.Value
+	}
+	if summaryProp != nil {
+		summary = summaryProp.Value
+	}
+	if descriptionProp != nil {
+		description = descriptionProp.Value
+	}
+	if locationProp != nil {
+		location = locationProp.Value
 	}
 
-	return nil, nil // Event not on target date
+	return Event{
+		UID:         uid,
+		Summary:     summary,
+		Description: description,
+		Location:    location,
+		Start:       start,
+		End:         end,
+		AllDay:      allDay,
+		Transparent: transparent,
+	}, nil
 }
 
-// parseICalTime parses an iCalendar date-time string into a time.Time, considering time zones.
-func parseICalTime(value string, prop *ics.IANAProperty) (time.Time, error) {
+// parseICalTime parses an iCalendar date-time string into a time.Time,
+// considering time zones. resolver is consulted first so a TZID backed by
+// an embedded VTIMEZONE resolves correctly even when it isn't a valid IANA
+// zone name (e.g. "Pacific Standard Time"); pass nil to fall back to plain
+// IANA lookups.
+func parseICalTime(value string, prop *ics.IANAProperty, resolver *tzResolver) (time.Time, error) {
 	if strings.HasSuffix(value, "Z") {
 		// UTC time
 		return time.Parse("20060102T150405Z", value)
@@ -215,11 +240,14 @@ func parseICalTime(value string, prop *ics.IANAProperty) (time.Time, error) {
 	loc := time.Local
 	if tzidVals, ok := prop.ICalParameters["TZID"]; ok {
 		if len(tzidVals) > 0 {
-			var err error
-			loc, err = time.LoadLocation(tzidVals[0])
+			// The VTIMEZONE's offset can vary by date (DST), so resolve it
+			// against this value's own naive wall-clock instant rather than
+			// a single zone-wide offset.
+			naive, err := time.Parse("20060102T150405", value)
 			if err != nil {
 				return time.Time{}, err
 			}
+			loc = resolver.resolve(tzidVals[0], naive)
 		}
 	}
 
@@ -231,6 +259,8 @@ func main() {
 
 	// Define the /caljson route
 	r.GET("/caljson", caljsonHandler)
+	r.GET("/freebusy", freebusyHandler)
+	r.GET("/upcoming", upcomingHandler)
 
 	fmt.Println("Server is listening on port 8030...")
 	if err := r.Run(":8030"); err != nil {
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// collectEvents walks every VEVENT in calendar and returns the Event
+// instances that overlap [windowStart, windowEnd). Recurring events (those
+// with an RRULE or RDATE) are expanded via expandOccurrences; components
+// carrying a RECURRENCE-ID override the matching expanded instance instead
+// of being emitted as separate events.
+func collectEvents(calendar *ics.Calendar, windowStart, windowEnd time.Time) ([]Event, error) {
+	resolver := newTZResolver(calendar, windowStart, windowEnd)
+
+	// overrides[uid][originalStart] holds the RECURRENCE-ID component that
+	// replaces the expanded occurrence starting at originalStart.
+	overrides := map[string]map[time.Time]Event{}
+
+	for _, component := range calendar.Components {
+		vevent, ok := component.(*ics.VEvent)
+		if !ok {
+			continue
+		}
+		recurrenceIDProp := vevent.GetProperty(ics.ComponentPropertyRecurrenceId)
+		if recurrenceIDProp == nil {
+			continue
+		}
+		uidProp := vevent.GetProperty(ics.ComponentPropertyUniqueId)
+		if uidProp == nil {
+			continue
+		}
+		recurrenceID, err := parseICalTime(recurrenceIDProp.Value, recurrenceIDProp, resolver)
+		if err != nil {
+			continue
+		}
+		override, err := parseEventCore(vevent, resolver)
+		if err != nil {
+			continue
+		}
+		override.RecurrenceID = &recurrenceID
+		if overrides[uidProp.Value] == nil {
+			overrides[uidProp.Value] = map[time.Time]Event{}
+		}
+		overrides[uidProp.Value][recurrenceID.UTC()] = override
+	}
+
+	var events []Event
+	for _, component := range calendar.Components {
+		vevent, ok := component.(*ics.VEvent)
+		if !ok {
+			continue
+		}
+		if vevent.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+			continue // handled as an override above
+		}
+
+		rruleProp := vevent.GetProperty(ics.ComponentPropertyRrule)
+		rdateProps := getProperties(vevent, "RDATE")
+		if rruleProp == nil && len(rdateProps) == 0 {
+			event, err := parseEvent(vevent, windowStart, windowEnd, resolver)
+			if err != nil {
+				continue
+			}
+			if event != nil {
+				events = append(events, *event)
+			}
+			continue
+		}
+
+		occurrences, err := expandOccurrences(vevent, windowStart, windowEnd, resolver)
+		if err != nil {
+			continue
+		}
+
+		var uidOverrides map[time.Time]Event
+		if uidProp := vevent.GetProperty(ics.ComponentPropertyUniqueId); uidProp != nil {
+			uidOverrides = overrides[uidProp.Value]
+		}
+		used := map[time.Time]bool{}
+		for _, occurrence := range occurrences {
+			if override, ok := uidOverrides[occurrence.Start.UTC()]; ok {
+				used[occurrence.Start.UTC()] = true
+				if override.End.After(windowStart) && override.Start.Before(windowEnd) {
+					events = append(events, override)
+				}
+				continue
+			}
+			events = append(events, occurrence)
+		}
+
+		// expandOccurrences only surfaces occurrences whose *natural* start
+		// falls in the window, so an override that reschedules an instance
+		// from outside the window to inside it is never matched above --
+		// it's simply missing from occurrences. Catch those here, keyed off
+		// the override's own (possibly moved) start/end instead.
+		for originalStart, override := range uidOverrides {
+			if used[originalStart] {
+				continue
+			}
+			if override.End.After(windowStart) && override.Start.Before(windowEnd) {
+				events = append(events, override)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// expandOccurrences expands vevent's RRULE/RDATE into individual Event
+// instances that overlap [windowStart, windowEnd), with EXDATE exclusions
+// removed. Unbounded rules (no COUNT or UNTIL) are naturally capped at
+// windowEnd since rule.Between never walks past it, so a calendar with a
+// never-ending RRULE can't make this loop forever. Each returned Event's
+// RecurrenceID is set to that instance's original start time, which
+// collectEvents uses to match it against a RECURRENCE-ID override.
+func expandOccurrences(vevent *ics.VEvent, windowStart, windowEnd time.Time, resolver *tzResolver) ([]Event, error) {
+	base, err := parseEventCore(vevent, resolver)
+	if err != nil {
+		return nil, err
+	}
+	duration := base.End.Sub(base.Start)
+	loc := base.Start.Location()
+
+	// DTSTART's own TZID, if any, so each rrule-generated occurrence can be
+	// re-resolved against it individually below instead of reusing loc (see
+	// the comment on resolveOccurrence for why that distinction matters).
+	tzid := ""
+	if startProp := vevent.GetProperty(ics.ComponentPropertyDtStart); startProp != nil {
+		if tzidVals, ok := startProp.ICalParameters["TZID"]; ok && len(tzidVals) > 0 {
+			tzid = tzidVals[0]
+		}
+	}
+
+	// starts is keyed by each occurrence's UTC instant, for RDATE/EXDATE
+	// dedup, but stores its correctly-zoned time.Time as the value so the
+	// final loop doesn't need to re-derive a Location from scratch.
+	starts := map[time.Time]time.Time{base.Start.UTC(): base.Start}
+
+	if rruleProp := vevent.GetProperty(ics.ComponentPropertyRrule); rruleProp != nil {
+		// StrToROption hardcodes UTC for a non-"Z" UNTIL; use loc instead so a
+		// floating event's UNTIL is interpreted in the same zone as its
+		// DTSTART, matching parseICalTime's floating-time behavior.
+		option, err := rrule.StrToROptionInLocation(rruleProp.Value, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE: %w", err)
+		}
+		// DTSTART's zone drives wall-clock recurrence (and any UNTIL in the
+		// same RRULE is interpreted relative to it), so DST transitions land
+		// on the correct local time rather than drifting by an hour.
+		option.Dtstart = base.Start
+		rule, err := rrule.NewRRule(*option)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE: %w", err)
+		}
+		// rule.Between only returns starts inside the window itself, so an
+		// occurrence that started before windowStart but whose duration
+		// carries it into the window (an overnight shift, say) would never
+		// be seeded -- widen the query by duration and let the overlap
+		// filter below discard anything that doesn't actually overlap.
+		for _, t := range rule.Between(windowStart.Add(-duration), windowEnd, true) {
+			occurrence := resolveOccurrence(t, tzid, resolver)
+			starts[occurrence.UTC()] = occurrence
+		}
+	}
+
+	for _, rdateProp := range getProperties(vevent, "RDATE") {
+		for _, value := range strings.Split(rdateProp.Value, ",") {
+			t, err := parseICalTime(value, rdateProp, resolver)
+			if err != nil {
+				continue
+			}
+			starts[t.UTC()] = t
+		}
+	}
+
+	for _, exdateProp := range getProperties(vevent, "EXDATE") {
+		for _, value := range strings.Split(exdateProp.Value, ",") {
+			t, err := parseICalTime(value, exdateProp, resolver)
+			if err != nil {
+				continue
+			}
+			delete(starts, t.UTC())
+		}
+	}
+
+	var occurrences []Event
+	for _, localStart := range starts {
+		localEnd := localStart.Add(duration)
+		if !localEnd.After(windowStart) || !localStart.Before(windowEnd) {
+			continue
+		}
+		recurrenceID := localStart
+		occurrence := base
+		occurrence.Start = localStart
+		occurrence.End = localEnd
+		occurrence.RecurrenceID = &recurrenceID
+		occurrences = append(occurrences, occurrence)
+	}
+
+	return occurrences, nil
+}
+
+// resolveOccurrence re-resolves an rrule-generated occurrence's wall-clock
+// instant against tzid, rather than trusting t's own Location: rrule.NewRRule
+// builds every occurrence in DTSTART's Location (option.Dtstart above), and
+// when that Location came from an embedded VTIMEZONE it's a time.FixedZone
+// valid only at the instant tzResolver.resolve derived it for. Reusing that
+// single frozen offset across a whole series would leave every occurrence on
+// the far side of a DST transition silently off by the transition's delta.
+// Events whose DTSTART carries no TZID (tzid == "") are returned unchanged,
+// since t's Location (UTC, time.Local, or a real IANA zone) already resolves
+// DST correctly on its own.
+func resolveOccurrence(t time.Time, tzid string, resolver *tzResolver) time.Time {
+	if tzid == "" {
+		return t
+	}
+	naive := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), resolver.resolve(tzid, naive))
+}
+
+// getProperties returns every property on vevent whose name matches name.
+// GetProperty only ever returns the first match, which isn't good enough
+// for RDATE/EXDATE: both can legally repeat across several properties in
+// addition to packing a comma-separated list into a single value.
+func getProperties(vevent *ics.VEvent, name string) []*ics.IANAProperty {
+	var matches []*ics.IANAProperty
+	for i := range vevent.Properties {
+		if vevent.Properties[i].IANAToken == name {
+			matches = append(matches, &vevent.Properties[i])
+		}
+	}
+	return matches
+}
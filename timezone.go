@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// componentPropertyTzOffsetTo is TZOFFSETTO, which golang-ical doesn't
+// define a named constant for since STANDARD/DAYLIGHT aren't modeled as
+// bespoke component types.
+const componentPropertyTzOffsetTo = ics.ComponentProperty("TZOFFSETTO")
+
+// tzSubComponent is the common surface buildZoneFromVTimezone needs from a
+// VTIMEZONE sub-component; *ics.Standard and *ics.Daylight both satisfy it
+// via their embedded ComponentBase.
+type tzSubComponent interface {
+	GetProperty(ics.ComponentProperty) *ics.IANAProperty
+}
+
+// tzTransitionPad extends the range we expand STANDARD/DAYLIGHT RRULEs over,
+// beyond the request's own window, so a window that sits close to a
+// transition (e.g. the first week of November, when US DST ends) still has
+// the surrounding year's transitions available to resolve against.
+const tzTransitionPad = 370 * 24 * time.Hour
+
+// tzTransition is one STANDARD/DAYLIGHT offset change. start is the naive
+// local wall-clock instant (VTIMEZONE DTSTART/RRULE values carry no zone of
+// their own) the new offset takes effect.
+type tzTransition struct {
+	start  time.Time
+	offset int
+}
+
+// tzZone is a VTIMEZONE's offset history, sorted ascending by start, dense
+// enough to cover the resolver's requested window.
+type tzZone struct {
+	transitions []tzTransition
+}
+
+// offsetAt returns the offset in effect at the given naive local instant:
+// the most recent transition at or before it, or the earliest known
+// transition's offset if at predates everything we expanded.
+func (z *tzZone) offsetAt(at time.Time) int {
+	offset := z.transitions[0].offset
+	for _, t := range z.transitions {
+		if t.start.After(at) {
+			break
+		}
+		offset = t.offset
+	}
+	return offset
+}
+
+// tzResolver resolves a TZID to a *time.Location for a specific instant,
+// preferring a VTIMEZONE block embedded in the calendar over an IANA
+// zoneinfo lookup. Many calendars (Outlook, Exchange, custom exporters)
+// emit TZIDs like "Pacific Standard Time" that don't match any IANA name
+// but do come with an inline VTIMEZONE defining STANDARD/DAYLIGHT
+// transitions.
+type tzResolver struct {
+	zones map[string]*tzZone
+}
+
+// newTZResolver parses every VTIMEZONE in calendar into a tzZone, expanding
+// its STANDARD/DAYLIGHT RRULEs across [windowStart, windowEnd] (padded by
+// tzTransitionPad) so the zone's DST transitions around the request's
+// window are known, keyed by TZID. It's built once per request and
+// threaded through event parsing instead of re-parsed per event.
+func newTZResolver(calendar *ics.Calendar, windowStart, windowEnd time.Time) *tzResolver {
+	resolver := &tzResolver{zones: make(map[string]*tzZone)}
+	rangeStart := windowStart.Add(-tzTransitionPad)
+	rangeEnd := windowEnd.Add(tzTransitionPad)
+
+	for _, component := range calendar.Components {
+		vtimezone, ok := component.(*ics.VTimezone)
+		if !ok {
+			continue
+		}
+		tzidProp := vtimezone.GetProperty(ics.ComponentPropertyTzid)
+		if tzidProp == nil {
+			continue
+		}
+		zone, err := buildZoneFromVTimezone(vtimezone, rangeStart, rangeEnd)
+		if err != nil {
+			log.Printf("caljson: could not build zone for VTIMEZONE %q: %v", tzidProp.Value, err)
+			continue
+		}
+		resolver.zones[tzidProp.Value] = zone
+	}
+
+	return resolver
+}
+
+// resolve looks up tzid, preferring the calendar's own VTIMEZONE
+// transitions to find the offset in effect at the given instant, falling
+// back to an IANA zoneinfo lookup, and finally UTC -- logging a warning in
+// the fallback case so events aren't silently mis-zoned. resolver may be
+// nil, in which case it behaves as if no VTIMEZONE blocks were found.
+func (r *tzResolver) resolve(tzid string, at time.Time) *time.Location {
+	if r != nil {
+		if zone, ok := r.zones[tzid]; ok && len(zone.transitions) > 0 {
+			return time.FixedZone(tzid, zone.offsetAt(at))
+		}
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	log.Printf("caljson: could not resolve TZID %q to any zone, defaulting to UTC", tzid)
+	return time.UTC
+}
+
+// buildZoneFromVTimezone expands a VTIMEZONE's STANDARD/DAYLIGHT
+// sub-components into a sorted list of offset transitions covering
+// [rangeStart, rangeEnd], so resolving any instant in that range picks the
+// correct one of the alternating STANDARD/DAYLIGHT offsets instead of a
+// single offset fixed for all time.
+func buildZoneFromVTimezone(vtimezone *ics.VTimezone, rangeStart, rangeEnd time.Time) (*tzZone, error) {
+	var transitions []tzTransition
+
+	for _, sub := range vtimezone.Components {
+		// STANDARD/DAYLIGHT parse into their own types, not a generic
+		// fallback component, but both embed ComponentBase so GetProperty
+		// works the same either way.
+		var general tzSubComponent
+		switch c := sub.(type) {
+		case *ics.Standard:
+			general = c
+		case *ics.Daylight:
+			general = c
+		default:
+			continue
+		}
+
+		dtstartProp := general.GetProperty(ics.ComponentPropertyDtStart)
+		offsetProp := general.GetProperty(componentPropertyTzOffsetTo)
+		if dtstartProp == nil || offsetProp == nil {
+			continue
+		}
+
+		dtstart, err := time.Parse("20060102T150405", dtstartProp.Value)
+		if err != nil {
+			continue
+		}
+		offset, err := parseUTCOffset(offsetProp.Value)
+		if err != nil {
+			continue
+		}
+
+		// The sub-component's own DTSTART is always a valid transition,
+		// even if it falls outside the requested range (it's then the
+		// offset in effect up to the first in-range occurrence).
+		transitions = append(transitions, tzTransition{start: dtstart, offset: offset})
+
+		if rruleProp := general.GetProperty(ics.ComponentPropertyRrule); rruleProp != nil {
+			option, err := rrule.StrToROption(rruleProp.Value)
+			if err == nil {
+				option.Dtstart = dtstart
+				if rule, err := rrule.NewRRule(*option); err == nil {
+					for _, occurrence := range rule.Between(rangeStart, rangeEnd, true) {
+						transitions = append(transitions, tzTransition{start: occurrence, offset: offset})
+					}
+				}
+			}
+		}
+	}
+
+	if len(transitions) == 0 {
+		return nil, fmt.Errorf("no STANDARD/DAYLIGHT offset found")
+	}
+
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].start.Before(transitions[j].start)
+	})
+
+	return &tzZone{transitions: transitions}, nil
+}
+
+// parseUTCOffset parses a TZOFFSETTO/TZOFFSETFROM value like "-0800" or
+// "+0530" into a signed number of seconds east of UTC.
+func parseUTCOffset(value string) (int, error) {
+	if len(value) < 5 {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	var sign int
+	switch value[0] {
+	case '+':
+		sign = 1
+	case '-':
+		sign = -1
+	default:
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q: %w", value, err)
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q: %w", value, err)
+	}
+
+	return sign * (hours*3600 + minutes*60), nil
+}
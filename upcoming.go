@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upcomingDefaultLimit is how many occurrences /upcoming returns when the
+// caller doesn't specify `limit`.
+const upcomingDefaultLimit = 10
+
+// upcomingDefaultHorizon bounds how far into the future /upcoming will walk
+// looking for occurrences, so a calendar with too few (or no) future events
+// doesn't make the handler scan forever.
+const upcomingDefaultHorizon = 365 * 24 * time.Hour
+
+// upcomingChunk is the width of each widening lookahead window. Starting
+// small keeps the common case -- a handful of occurrences in the next few
+// weeks -- cheap to expand, while still reaching the full horizon for
+// sparser calendars.
+const upcomingChunk = 7 * 24 * time.Hour
+
+// upcomingHandler handles the /upcoming endpoint: the next `limit` event
+// instances from now, across both plain events and expanded recurrences,
+// sorted by start time. It walks forward in widening chunks rather than
+// expanding the whole horizon up front, so the common case of a handful of
+// near-term occurrences stays cheap.
+func upcomingHandler(c *gin.Context) {
+	if c.Query("ics") == "" && c.Query("caldav") == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Missing 'ics' or 'caldav' parameter"})
+		return
+	}
+
+	limit := upcomingDefaultLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid 'limit' parameter"})
+			return
+		}
+		limit = parsed
+	}
+
+	horizon := upcomingDefaultHorizon
+	if horizonStr := c.Query("horizon"); horizonStr != "" {
+		parsed, err := time.ParseDuration(horizonStr)
+		if err != nil || parsed <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid 'horizon' parameter"})
+			return
+		}
+		horizon = parsed
+	}
+
+	now := time.Now()
+	horizonEnd := now.Add(horizon)
+
+	calendar, _, err := fetchCalendarSource(c, now, horizonEnd)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var events []Event
+	// seen dedups occurrences by UID+start: an event spanning a chunk
+	// boundary (a multi-day event, or a recurring instance straddling the
+	// cutoff) satisfies the overlap check in both the chunk it starts in
+	// and the next one, so collectEvents would otherwise return it twice.
+	seen := make(map[string]bool)
+	chunkStart := now
+	for chunkWidth := upcomingChunk; chunkStart.Before(horizonEnd); chunkWidth *= 2 {
+		chunkEnd := chunkStart.Add(chunkWidth)
+		if chunkEnd.After(horizonEnd) {
+			chunkEnd = horizonEnd
+		}
+
+		chunkEvents, err := collectEvents(calendar, chunkStart, chunkEnd)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process calendar events"})
+			return
+		}
+		for _, event := range chunkEvents {
+			key := event.UID + "|" + event.Start.UTC().Format(time.RFC3339Nano)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			events = append(events, event)
+		}
+
+		if len(events) >= limit {
+			break
+		}
+		chunkStart = chunkEnd
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isoWeekPattern matches the ISO 8601 "YYYY-Www" form exactly, with no
+// trailing content -- fmt.Sscanf alone stops at the first non-matching rune
+// and happily accepts garbage tacked on after a valid prefix.
+var isoWeekPattern = regexp.MustCompile(`^\d{4}-W\d{2}$`)
+
+// resolveWindow determines the [start, end) time window a request is asking
+// about. In order of precedence it honors an explicit from/to range, a
+// week=YYYY-Www or month=YYYY-MM convenience parameter, and finally the
+// original day=N offset-from-today parameter. The window is anchored in the
+// zone named by the tz query parameter (an IANA name), defaulting to the
+// server's local zone; from/to values keep whatever offset they're written
+// with, but are converted into tz for consistency with the other modes.
+func resolveWindow(c *gin.Context) (time.Time, time.Time, error) {
+	loc := time.Local
+	if tzName := c.Query("tz"); tzName != "" {
+		l, err := time.LoadLocation(tzName)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'tz' parameter: %w", err)
+		}
+		loc = l
+	}
+
+	fromStr := c.Query("from")
+	toStr := c.Query("to")
+	if fromStr != "" || toStr != "" {
+		if fromStr == "" || toStr == "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("'from' and 'to' must be supplied together")
+		}
+		from, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'from' parameter: %w", err)
+		}
+		to, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'to' parameter: %w", err)
+		}
+		return from.In(loc), to.In(loc), nil
+	}
+
+	if weekStr := c.Query("week"); weekStr != "" {
+		year, week, err := parseISOWeek(weekStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'week' parameter: %w", err)
+		}
+		start := isoWeekStart(year, week, loc)
+		return start, start.AddDate(0, 0, 7), nil
+	}
+
+	if monthStr := c.Query("month"); monthStr != "" {
+		monthStart, err := time.ParseInLocation("2006-01", monthStr, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'month' parameter: %w", err)
+		}
+		return monthStart, monthStart.AddDate(0, 1, 0), nil
+	}
+
+	dayOffset, err := strconv.Atoi(c.DefaultQuery("day", "0"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid 'day' parameter: %w", err)
+	}
+	targetDate := time.Now().In(loc).AddDate(0, 0, dayOffset)
+	start := time.Date(targetDate.Year(), targetDate.Month(), targetDate.Day(), 0, 0, 0, 0, loc)
+	return start, start.Add(24 * time.Hour), nil
+}
+
+// parseISOWeek parses the ISO 8601 "YYYY-Www" form (e.g. "2026-W05").
+func parseISOWeek(value string) (year, week int, err error) {
+	if !isoWeekPattern.MatchString(value) {
+		return 0, 0, fmt.Errorf("expected YYYY-Www")
+	}
+	if _, err := fmt.Sscanf(value, "%d-W%d", &year, &week); err != nil {
+		return 0, 0, fmt.Errorf("expected YYYY-Www")
+	}
+	if week < 1 || week > 53 {
+		return 0, 0, fmt.Errorf("week out of range: %d", week)
+	}
+	return year, week, nil
+}
+
+// isoWeekStart returns the Monday (midnight, in loc) that begins ISO week
+// `week` of `year`.
+func isoWeekStart(year, week int, loc *time.Location) time.Time {
+	// January 4th always falls in ISO week 1, so anchoring to it and
+	// walking back to that week's Monday gives week 1's start regardless of
+	// what day January 1st lands on.
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, loc)
+	weekday := int(jan4.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	week1Monday := jan4.AddDate(0, 0, -(weekday - 1))
+	return week1Monday.AddDate(0, 0, (week-1)*7)
+}